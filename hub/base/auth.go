@@ -0,0 +1,46 @@
+package base
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/byuoitav/common/nerr"
+)
+
+//TokenSource supplies a bearer token to authenticate with the hub. It is consulted before every dial
+//attempt (including retries), so it's the right place to mint or refresh a short-lived credential
+//(e.g. a JWT nearing expiry) without the caller having to manage that separately.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+//StaticTokenSource is a TokenSource that always returns the same token string.
+type StaticTokenSource string
+
+//Token implements TokenSource.
+func (s StaticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+//FileTokenSource is a TokenSource that re-reads a token from a file on disk on every call, so an
+//externally rotated credential file is picked up without restarting the process.
+type FileTokenSource string
+
+//Token implements TokenSource.
+func (f FileTokenSource) Token() (string, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return "", nerr.Create(fmt.Sprintf("failed to read token file %v: %s", string(f), err), "auth-error")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+//TokenSourceFunc adapts a plain function (e.g. one that mints/refreshes a JWT) to a TokenSource.
+type TokenSourceFunc func() (string, error)
+
+//Token implements TokenSource.
+func (f TokenSourceFunc) Token() (string, error) {
+	return f()
+}
@@ -0,0 +1,141 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/byuoitav/common/nerr"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Subprotocol names advertised during the websocket handshake (Sec-WebSocket-Protocol) so both
+// sides of a connection can agree on a wire format without the caller hard-coding one.
+const (
+	BinarySubprotocol  = "ces.binary.v1"
+	JSONSubprotocol    = "ces.json.v1"
+	MsgpackSubprotocol = "ces.msgpack.v1"
+)
+
+//Codec encodes/decodes an EventWrapper to/from the bytes sent over the wire. Implementations must
+//be safe to share between a HubConnection/PumpingStation's read and write pumps.
+type Codec interface {
+	//Encode serializes an EventWrapper into the bytes to write to the socket.
+	Encode(EventWrapper) ([]byte, error)
+
+	//Decode parses the bytes read off the socket back into an EventWrapper.
+	Decode([]byte) (EventWrapper, error)
+
+	//MessageType is the gorilla/websocket message type (e.g. websocket.BinaryMessage, websocket.TextMessage) to use when writing.
+	MessageType() int
+
+	//Subprotocol is the value to advertise/match in the Sec-WebSocket-Protocol handshake header.
+	Subprotocol() string
+}
+
+//CodecForSubprotocol returns the Codec matching a negotiated subprotocol string, falling back to
+//BinaryCodec when the peer didn't advertise support for anything we recognize.
+func CodecForSubprotocol(subprotocol string) Codec {
+	switch subprotocol {
+	case JSONSubprotocol:
+		return JSONCodec{}
+	case MsgpackSubprotocol:
+		return MsgpackCodec{}
+	default:
+		return BinaryCodec{}
+	}
+}
+
+//BinaryCodec is the original length-prefixed, room-prefixed binary framing used by ParseMessage/PrepareMessage.
+type BinaryCodec struct{}
+
+//Encode implements Codec.
+func (BinaryCodec) Encode(e EventWrapper) ([]byte, error) {
+	return PrepareMessage(e), nil
+}
+
+//Decode implements Codec.
+func (BinaryCodec) Decode(b []byte) (EventWrapper, error) {
+	e, err := ParseMessage(b)
+	if err != nil {
+		return EventWrapper{}, nerr.Create(fmt.Sprintf("failed to decode binary message: %s", err), "codec-error")
+	}
+	return e, nil
+}
+
+//MessageType implements Codec.
+func (BinaryCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+//Subprotocol implements Codec.
+func (BinaryCodec) Subprotocol() string {
+	return BinarySubprotocol
+}
+
+//JSONCodec encodes an EventWrapper as plain JSON, matching the framing repeater.PumpingStation already
+//speaks with ReadJSON/WriteJSON. Useful for non-Go consumers (dashboards, browsers) that don't want to
+//implement the room-prefixed binary framing.
+type JSONCodec struct{}
+
+//Encode implements Codec.
+func (JSONCodec) Encode(e EventWrapper) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, nerr.Create(fmt.Sprintf("failed to encode json message: %s", err), "codec-error")
+	}
+	return b, nil
+}
+
+//Decode implements Codec.
+func (JSONCodec) Decode(b []byte) (EventWrapper, error) {
+	var e EventWrapper
+	err := json.Unmarshal(b, &e)
+	if err != nil {
+		return EventWrapper{}, nerr.Create(fmt.Sprintf("failed to decode json message: %s", err), "codec-error")
+	}
+	return e, nil
+}
+
+//MessageType implements Codec.
+func (JSONCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+//Subprotocol implements Codec.
+func (JSONCodec) Subprotocol() string {
+	return JSONSubprotocol
+}
+
+//MsgpackCodec encodes an EventWrapper as MessagePack, trading the readability of JSON for a more
+//compact wire size.
+type MsgpackCodec struct{}
+
+//Encode implements Codec.
+func (MsgpackCodec) Encode(e EventWrapper) ([]byte, error) {
+	b, err := msgpack.Marshal(e)
+	if err != nil {
+		return nil, nerr.Create(fmt.Sprintf("failed to encode msgpack message: %s", err), "codec-error")
+	}
+	return b, nil
+}
+
+//Decode implements Codec.
+func (MsgpackCodec) Decode(b []byte) (EventWrapper, error) {
+	var e EventWrapper
+	err := msgpack.Unmarshal(b, &e)
+	if err != nil {
+		return EventWrapper{}, nerr.Create(fmt.Sprintf("failed to decode msgpack message: %s", err), "codec-error")
+	}
+	return e, nil
+}
+
+//MessageType implements Codec.
+func (MsgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+//Subprotocol implements Codec.
+func (MsgpackCodec) Subprotocol() string {
+	return MsgpackSubprotocol
+}
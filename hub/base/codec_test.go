@@ -0,0 +1,53 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	event := EventWrapper{Room: "ITB-1101", Event: []byte(`{"hello":"world"}`)}
+
+	codecs := map[string]Codec{
+		"binary":  BinaryCodec{},
+		"json":    JSONCodec{},
+		"msgpack": MsgpackCodec{},
+	}
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			b, err := codec.Encode(event)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			got, err := codec.Decode(b)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, event) {
+				t.Errorf("round trip mismatch: got %+v, want %+v", got, event)
+			}
+		})
+	}
+}
+
+func TestCodecForSubprotocol(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		want        Codec
+	}{
+		{JSONSubprotocol, JSONCodec{}},
+		{MsgpackSubprotocol, MsgpackCodec{}},
+		{BinarySubprotocol, BinaryCodec{}},
+		{"", BinaryCodec{}},
+		{"unknown", BinaryCodec{}},
+	}
+
+	for _, tt := range tests {
+		if got := CodecForSubprotocol(tt.subprotocol); got != tt.want {
+			t.Errorf("CodecForSubprotocol(%q) = %T, want %T", tt.subprotocol, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package base
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// Control frame types understood by the hub, used to manage a receiver's room subscriptions.
+const (
+	ControlSubscribe   = "SUBSCRIBE"
+	ControlUnsubscribe = "UNSUBSCRIBE"
+)
+
+//ControlFrame is a small, codec-independent envelope for connection management messages like
+//SUBSCRIBE/UNSUBSCRIBE. It is always sent as a websocket text frame and decoded before attempting to
+//parse a payload as a Codec-framed EventWrapper, so it works regardless of which Codec was negotiated.
+//Rooms may contain glob patterns (e.g. "ITB-*"), and "*" means the firehose (all rooms).
+type ControlFrame struct {
+	Type  string   `json:"type"`
+	Rooms []string `json:"rooms"`
+}
+
+//EncodeControlFrame serializes a ControlFrame to the bytes to send as a websocket text message.
+func EncodeControlFrame(c ControlFrame) ([]byte, error) {
+	return json.Marshal(c)
+}
+
+//DecodeControlFrame parses a websocket text message back into a ControlFrame.
+func DecodeControlFrame(b []byte) (ControlFrame, error) {
+	var c ControlFrame
+	err := json.Unmarshal(b, &c)
+	return c, err
+}
+
+//RoomMatches reports whether room satisfies any of the given glob patterns (e.g. "ITB-*"). A pattern
+//of exactly "*" always matches, regardless of room's contents.
+func RoomMatches(room string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == "*" {
+			return true
+		}
+
+		ok, err := filepath.Match(p, room)
+		if err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,43 @@
+package base
+
+import "testing"
+
+func TestRoomMatches(t *testing.T) {
+	tests := []struct {
+		room     string
+		patterns []string
+		want     bool
+	}{
+		{"ITB-1101", []string{"ITB-*"}, true},
+		{"ITB-1101", []string{"JRCB-*"}, false},
+		{"ITB-1101", []string{"*"}, true},
+		{"ITB-1101", nil, false},
+		{"ITB-1101", []string{"JRCB-*", "ITB-*"}, true},
+		{"ITB-1101", []string{"ITB-1101"}, true},
+		{"ITB-1102", []string{"ITB-1101"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := RoomMatches(tt.room, tt.patterns); got != tt.want {
+			t.Errorf("RoomMatches(%q, %v) = %v, want %v", tt.room, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestControlFrameEncodeDecode(t *testing.T) {
+	frame := ControlFrame{Type: ControlSubscribe, Rooms: []string{"ITB-*", "JRCB-101"}}
+
+	b, err := EncodeControlFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeControlFrame: %v", err)
+	}
+
+	got, err := DecodeControlFrame(b)
+	if err != nil {
+		t.Fatalf("DecodeControlFrame: %v", err)
+	}
+
+	if got.Type != frame.Type || len(got.Rooms) != len(frame.Rooms) {
+		t.Errorf("round trip mismatch: got %+v, want %+v", got, frame)
+	}
+}
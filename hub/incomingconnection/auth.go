@@ -0,0 +1,120 @@
+package incomingconnection
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/byuoitav/central-event-system/hub/base"
+	"github.com/byuoitav/common/nerr"
+)
+
+//Claims describes what a validated bearer token authorizes a connection to do.
+type Claims struct {
+	Subject string
+
+	//AllowedRooms is the set of room glob patterns (e.g. "ITB-*") this token may subscribe to or
+	//publish into. Empty means every room.
+	AllowedRooms []string
+
+	//AllowedConnTypes is the set of connection types (base.Messenger, base.Receiver, ...) this token
+	//may open. Empty means every connection type.
+	AllowedConnTypes []string
+
+	ExpiresAt time.Time
+}
+
+//Expired reports whether the claims' expiry (if any) has passed.
+func (c Claims) Expired() bool {
+	return !c.ExpiresAt.IsZero() && time.Now().After(c.ExpiresAt)
+}
+
+func (c Claims) allowsRoom(room string) bool {
+	if len(c.AllowedRooms) == 0 {
+		return true
+	}
+	return base.RoomMatches(room, c.AllowedRooms)
+}
+
+func (c Claims) allowsConnType(connType string) bool {
+	if len(c.AllowedConnTypes) == 0 {
+		return true
+	}
+	for _, t := range c.AllowedConnTypes {
+		if t == connType {
+			return true
+		}
+	}
+	return false
+}
+
+//Authenticator validates a bearer token and returns the Claims it grants.
+type Authenticator interface {
+	Authenticate(token string) (Claims, error)
+}
+
+//AuthenticatorFunc adapts a plain function to an Authenticator.
+type AuthenticatorFunc func(token string) (Claims, error)
+
+//Authenticate implements Authenticator.
+func (f AuthenticatorFunc) Authenticate(token string) (Claims, error) {
+	return f(token)
+}
+
+//StaticTokenAuthenticator authenticates against a fixed token->Claims table, for deployments that
+//mint tokens out of band (e.g. a config file read at startup) rather than verifying a signed JWT.
+type StaticTokenAuthenticator map[string]Claims
+
+//Authenticate implements Authenticator.
+func (s StaticTokenAuthenticator) Authenticate(token string) (Claims, error) {
+	claims, ok := s[token]
+	if !ok {
+		return Claims{}, nerr.Create("unknown bearer token", "auth-error")
+	}
+	if claims.Expired() {
+		return Claims{}, nerr.Create("bearer token expired", "auth-error")
+	}
+	return claims, nil
+}
+
+//TokenFromRequest extracts the bearer token from a request's Authorization header (e.g. "Bearer abc123").
+func TokenFromRequest(r *http.Request) (string, *nerr.E) {
+	h := r.Header.Get("Authorization")
+	if len(h) == 0 {
+		return "", nerr.Create("no Authorization header present", "auth-error")
+	}
+
+	parts := strings.SplitN(h, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", nerr.Create("Authorization header is not a bearer token", "auth-error")
+	}
+
+	return strings.TrimSpace(parts[1]), nil
+}
+
+//Authenticate validates r's bearer token against auth and checks that the resulting claims authorize
+//connType, returning an error the caller should answer with 401 otherwise. Call this before
+//upgrading the connection. A nil auth means authentication is disabled and always succeeds with
+//empty (unrestricted) Claims - e.g. for local dev against a hub with no configured Authenticator.
+func Authenticate(r *http.Request, auth Authenticator, connType string) (Claims, *nerr.E) {
+	if auth == nil {
+		return Claims{}, nil
+	}
+
+	token, err := TokenFromRequest(r)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	claims, authErr := auth.Authenticate(token)
+	if authErr != nil {
+		return Claims{}, nerr.Create(fmt.Sprintf("unauthorized: %s", authErr), "auth-error")
+	}
+
+	if !claims.allowsConnType(connType) {
+		return Claims{}, nerr.Create(fmt.Sprintf("token does not authorize connection type %v", connType), "auth-error")
+	}
+
+	return claims, nil
+}
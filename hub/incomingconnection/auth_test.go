@@ -0,0 +1,79 @@
+package incomingconnection
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/central-event-system/hub/base"
+)
+
+func TestStaticTokenAuthenticator(t *testing.T) {
+	auth := StaticTokenAuthenticator{
+		"good-token": Claims{Subject: "receiver-1"},
+		"expired-token": Claims{
+			Subject:   "receiver-2",
+			ExpiresAt: time.Now().Add(-time.Minute),
+		},
+	}
+
+	if _, err := auth.Authenticate("good-token"); err != nil {
+		t.Errorf("Authenticate(good-token) = %v, want success", err)
+	}
+	if _, err := auth.Authenticate("expired-token"); err == nil {
+		t.Error("Authenticate(expired-token) succeeded, want expiry error")
+	}
+	if _, err := auth.Authenticate("unknown-token"); err == nil {
+		t.Error("Authenticate(unknown-token) succeeded, want unknown-token error")
+	}
+}
+
+func TestTokenFromRequest(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/connect/messenger", nil)
+	r.Header.Set("Authorization", "Bearer abc123")
+
+	token, err := TokenFromRequest(r)
+	if err != nil {
+		t.Fatalf("TokenFromRequest: %s", err.Error())
+	}
+	if token != "abc123" {
+		t.Errorf("TokenFromRequest() = %q, want %q", token, "abc123")
+	}
+
+	r2, _ := http.NewRequest("GET", "/connect/messenger", nil)
+	if _, err := TokenFromRequest(r2); err == nil {
+		t.Error("TokenFromRequest() with no Authorization header should fail")
+	}
+}
+
+func TestAuthenticateRejectsDisallowedConnType(t *testing.T) {
+	auth := StaticTokenAuthenticator{
+		"messenger-only": Claims{AllowedConnTypes: []string{base.Messenger}},
+	}
+
+	r, _ := http.NewRequest("GET", "/connect/"+base.Receiver, nil)
+	r.Header.Set("Authorization", "Bearer messenger-only")
+
+	if _, err := Authenticate(r, auth, base.Receiver); err == nil {
+		t.Error("expected Authenticate to reject a connType outside the token's AllowedConnTypes")
+	}
+
+	r2, _ := http.NewRequest("GET", "/connect/"+base.Messenger, nil)
+	r2.Header.Set("Authorization", "Bearer messenger-only")
+
+	if _, err := Authenticate(r2, auth, base.Messenger); err != nil {
+		t.Errorf("expected Authenticate to allow connType %v, got %v", base.Messenger, err)
+	}
+}
+
+func TestAuthenticateNilAuthenticatorAllowsAll(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/connect/"+base.Receiver, nil)
+
+	claims, err := Authenticate(r, nil, base.Receiver)
+	if err != nil {
+		t.Fatalf("Authenticate with nil Authenticator should succeed, got %s", err.Error())
+	}
+	if claims.AllowedRooms != nil {
+		t.Errorf("expected empty Claims when auth is disabled, got %+v", claims)
+	}
+}
@@ -0,0 +1,103 @@
+//Package incomingconnection implements the hub side of a receiver/repeater websocket connection: it
+//tracks what a connection has told us (via SUBSCRIBE/UNSUBSCRIBE control frames) it wants to
+//receive, and decides whether a given room should be written back down the socket before anything is
+//marshaled with a base.Codec. Without this, a hub broadcasts every event to every connection and lets
+//the receiver filter locally.
+package incomingconnection
+
+import (
+	"sync"
+	"time"
+
+	"github.com/byuoitav/central-event-system/hub/base"
+)
+
+const (
+	//PingWait is how long the hub will wait for the next ping from a connected peer before treating the read deadline as lapsed.
+	PingWait = 60 * time.Second
+
+	//WriteWait bounds how long a single write (including pong/close control frames) is allowed to take.
+	WriteWait = 10 * time.Second
+)
+
+//IncomingConnection tracks the hub-side state of one connected receiver/repeater: the claims granted
+//by its bearer token (see Authenticate), and the set of rooms it has subscribed to via
+//SUBSCRIBE/UNSUBSCRIBE control frames.
+type IncomingConnection struct {
+	ID         string
+	ConnType   string
+	RemoteAddr string
+	Claims     Claims
+
+	subMu         sync.Mutex
+	firehose      bool // true until the first SUBSCRIBE frame narrows us to an explicit room set
+	subscriptions map[string]bool
+}
+
+//New wraps an already-authenticated connection (see Authenticate) as an IncomingConnection. It
+//defaults to the firehose (every room the claims allow), matching the pre-SUBSCRIBE behavior until
+//the peer sends its first SUBSCRIBE frame.
+func New(id, connType, remoteAddr string, claims Claims) *IncomingConnection {
+	return &IncomingConnection{
+		ID:            id,
+		ConnType:      connType,
+		RemoteAddr:    remoteAddr,
+		Claims:        claims,
+		firehose:      true,
+		subscriptions: make(map[string]bool),
+	}
+}
+
+//HandleControlFrame applies a SUBSCRIBE/UNSUBSCRIBE control frame received from the peer, decoded
+//with base.DecodeControlFrame before the payload is ever handed to a base.Codec. The first SUBSCRIBE
+//a connection sends narrows it from the default firehose down to exactly the rooms requested.
+//Requested rooms outside the connection's claims are dropped rather than silently granted.
+func (c *IncomingConnection) HandleControlFrame(frame base.ControlFrame) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	switch frame.Type {
+	case base.ControlSubscribe:
+		c.firehose = false
+		for _, room := range frame.Rooms {
+			if c.Claims.allowsRoom(room) {
+				c.subscriptions[room] = true
+			}
+		}
+	case base.ControlUnsubscribe:
+		for _, room := range frame.Rooms {
+			delete(c.subscriptions, room)
+		}
+	}
+}
+
+//Wants reports whether this connection's current subscriptions cover room, so the hub can filter
+//outgoing events per-connection instead of broadcasting every room to every peer. A connection never
+//wants a room outside its own claims, regardless of its subscription set.
+func (c *IncomingConnection) Wants(room string) bool {
+	if !c.Claims.allowsRoom(room) {
+		return false
+	}
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if c.firehose {
+		return true
+	}
+
+	for pattern := range c.subscriptions {
+		if base.RoomMatches(room, []string{pattern}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//WantsPublish reports whether this connection's token authorizes it to publish an event for room,
+//independent of its own subscription set (a connection can be authorized to publish into a room it
+//isn't itself subscribed to receive).
+func (c *IncomingConnection) WantsPublish(room string) bool {
+	return c.Claims.allowsRoom(room)
+}
@@ -0,0 +1,65 @@
+package incomingconnection
+
+import (
+	"testing"
+
+	"github.com/byuoitav/central-event-system/hub/base"
+)
+
+func TestNewDefaultsToFirehose(t *testing.T) {
+	c := New("conn-1", base.Receiver, "127.0.0.1:1234", Claims{})
+
+	if !c.Wants("ITB-1101") {
+		t.Error("a freshly connected peer should receive every room until it subscribes")
+	}
+}
+
+func TestSubscribeNarrowsToRequestedRooms(t *testing.T) {
+	c := New("conn-1", base.Receiver, "127.0.0.1:1234", Claims{})
+
+	c.HandleControlFrame(base.ControlFrame{Type: base.ControlSubscribe, Rooms: []string{"ITB-*"}})
+
+	if !c.Wants("ITB-1101") {
+		t.Error("expected ITB-1101 to be wanted after subscribing to ITB-*")
+	}
+	if c.Wants("JRCB-101") {
+		t.Error("expected JRCB-101 to not be wanted: firehose subscription from New() should be replaced, not just added to")
+	}
+}
+
+func TestUnsubscribeRemovesRoom(t *testing.T) {
+	c := New("conn-1", base.Receiver, "127.0.0.1:1234", Claims{})
+
+	c.HandleControlFrame(base.ControlFrame{Type: base.ControlSubscribe, Rooms: []string{"ITB-*", "JRCB-101"}})
+	c.HandleControlFrame(base.ControlFrame{Type: base.ControlUnsubscribe, Rooms: []string{"JRCB-101"}})
+
+	if !c.Wants("ITB-1101") {
+		t.Error("expected ITB-1101 to still be wanted")
+	}
+	if c.Wants("JRCB-101") {
+		t.Error("expected JRCB-101 to no longer be wanted after unsubscribing")
+	}
+}
+
+func TestClaimsRestrictRoomsRegardlessOfSubscription(t *testing.T) {
+	c := New("conn-1", base.Receiver, "127.0.0.1:1234", Claims{AllowedRooms: []string{"ITB-*"}})
+
+	if c.Wants("JRCB-101") {
+		t.Error("a room outside the token's claims should never be wanted, even on the default firehose")
+	}
+
+	c.HandleControlFrame(base.ControlFrame{Type: base.ControlSubscribe, Rooms: []string{"ITB-*", "JRCB-101"}})
+
+	if c.Wants("JRCB-101") {
+		t.Error("SUBSCRIBE should not be able to grant a room outside the token's claims")
+	}
+	if !c.Wants("ITB-1101") {
+		t.Error("expected ITB-1101 to still be wanted: it's within both the subscription and the claims")
+	}
+	if !c.WantsPublish("ITB-1101") {
+		t.Error("expected publish to ITB-1101 to be authorized by the claims")
+	}
+	if c.WantsPublish("JRCB-101") {
+		t.Error("expected publish to JRCB-101 to be rejected: outside the token's claims")
+	}
+}
@@ -1,11 +1,17 @@
 package hubconnection
 
 import (
+	"crypto/tls"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/byuoitav/central-event-system/hub/base"
 	"github.com/byuoitav/central-event-system/hub/incomingconnection"
+	"github.com/byuoitav/central-event-system/messenger/hubconnection/spool"
+	"github.com/byuoitav/central-event-system/metrics"
 	"github.com/byuoitav/common/log"
 	"github.com/byuoitav/common/nerr"
 	"github.com/fatih/color"
@@ -13,8 +19,26 @@ import (
 )
 
 const (
-	// Interval to wait between retry attempts
-	retryInterval = 3 * time.Second
+	// defaultInitialBackoff is the delay before the first reconnect attempt.
+	defaultInitialBackoff = 2 * time.Second
+
+	// defaultMaxBackoff caps how long we'll ever wait between reconnect attempts.
+	defaultMaxBackoff = 64 * time.Second
+
+	// defaultMultiplier is how much the backoff grows after each failed attempt.
+	defaultMultiplier = 2.0
+
+	// defaultJitterFraction randomizes the delay by up to +/- this fraction to avoid thundering herds.
+	defaultJitterFraction = 0.2
+
+	// defaultPingInterval is how often we send an application-level ping to the hub.
+	defaultPingInterval = 30 * time.Second
+
+	// defaultPongWait is the default PongWait: added to PingInterval to set how far each pong pushes the read deadline out.
+	defaultPongWait = 10 * time.Second
+
+	// defaultWriteWait bounds how long a single write (including ping control frames) is allowed to take.
+	defaultWriteWait = 10 * time.Second
 )
 
 //HubConnection is the connection from this receiver to a hub
@@ -22,8 +46,64 @@ type HubConnection struct {
 	ID             string
 	ConnectionType string
 
-	writeChannel chan base.EventWrapper
-	readChannel  chan base.EventWrapper
+	// Codec controls how EventWrappers are framed on the wire and is negotiated with the hub via the
+	// websocket subprotocol at connect time. Defaults to base.BinaryCodec{} if unset.
+	Codec base.Codec
+
+	// InitialBackoff is the delay before the first reconnect attempt. Defaults to 2s.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between reconnect attempts. Defaults to 64s.
+	MaxBackoff time.Duration
+
+	// Multiplier is applied to the backoff after each failed attempt. Defaults to 2.0.
+	Multiplier float64
+
+	// JitterFraction randomizes each delay by +/- this fraction. Defaults to 0.2 (20%).
+	JitterFraction float64
+
+	// MaxAttempts bounds how many times retryConnection will try before giving up. 0 means unlimited.
+	MaxAttempts int
+
+	// OnRetry, if set, is called before each sleep with the attempt number (starting at 1) and the delay about to be used.
+	OnRetry func(attempt int, delay time.Duration)
+
+	// PingInterval is how often the write pump sends an application-level ping to the hub. Defaults to 30s.
+	PingInterval time.Duration
+
+	// PongWait, added to PingInterval, is how far each pong from the hub pushes the read deadline out (never
+	// less than incomingconnection.PingWait); the connection is torn down once that deadline passes with no
+	// further pong or hub ping. Defaults to 10s.
+	PongWait time.Duration
+
+	// WriteWait bounds how long a single write (including ping control frames) is allowed to take. Defaults to 10s.
+	WriteWait time.Duration
+
+	// SpoolDir, if set, durably spools events to disk so SendEvent survives a multi-minute hub outage
+	// instead of blocking on (or being lost by) the in-memory writeChannel. Leave empty to keep the
+	// original in-memory-only behavior.
+	SpoolDir string
+
+	// SpoolMaxBytes bounds the on-disk size of the spool. Defaults to 64MB. Only used when SpoolDir is set.
+	SpoolMaxBytes int64
+
+	// TokenSource, if set, supplies a bearer token sent as "Authorization: Bearer <token>" on every dial
+	// attempt. Since it's consulted fresh on every attempt (including retries), a TokenSource that mints
+	// or refreshes short-lived tokens naturally recovers from the hub rejecting a connection with 401.
+	TokenSource base.TokenSource
+
+	// TLSConfig, if set, dials the hub with wss:// using this config instead of a plaintext ws:// connection.
+	TLSConfig *tls.Config
+
+	spool     *spool.Spool
+	spoolAcks chan spoolResult
+
+	subMu         sync.Mutex
+	subscriptions map[string]bool
+
+	controlChannel chan base.ControlFrame
+	writeChannel   chan writeMessage
+	readChannel    chan base.EventWrapper
 
 	conn    *websocket.Conn
 	hubAddr string
@@ -31,12 +111,200 @@ type HubConnection struct {
 	readDone     chan bool
 	writeDone    chan bool
 	lastPingTime time.Time
+	connectedAt  time.Time
 	state        string
+
+	backoffMu      sync.Mutex
+	currentBackoff time.Duration
+	attempt        int
+	backedOff      bool // whether nextBackoff has run since the last resetBackoff
+}
+
+//writeMessage is what flows through writeChannel. Seq is non-zero when the event came from the spool,
+//so the write pump knows to acknowledge it once the write succeeds.
+type writeMessage struct {
+	Seq   uint64
+	Event base.EventWrapper
+}
+
+//spoolResult is what the write pump reports back on spoolAcks for every spool-sourced writeMessage, so
+//drainSpool can tell a successful write (safe to Ack) from a failed one (leave pending, re-Peek it once
+//the write pump is back up) instead of blocking forever waiting for an ack that a failed write never sends.
+type spoolResult struct {
+	Seq uint64
+	OK  bool
+}
+
+//ConnectionStats is a snapshot of a HubConnection's current reconnect state, useful for logging/health checks.
+type ConnectionStats struct {
+	State     string
+	Attempt   int
+	NextDelay time.Duration
+	LastPing  time.Time
 }
 
-//SendEvent will queue an event to be sent to the central hub
+//Stats returns a snapshot of the connection's current state, attempt count, and next reconnect delay.
+func (h *HubConnection) Stats() ConnectionStats {
+	h.backoffMu.Lock()
+	defer h.backoffMu.Unlock()
+
+	return ConnectionStats{
+		State:     h.state,
+		Attempt:   h.attempt,
+		NextDelay: h.currentBackoff,
+		LastPing:  h.lastPingTime,
+	}
+}
+
+//Health implements metrics.HealthProvider, reporting enough state to wire this connection into a /healthz endpoint.
+func (h *HubConnection) Health() metrics.ConnectionHealth {
+	h.backoffMu.Lock()
+	state := h.state
+	backoff := h.currentBackoff
+	lastPing := h.lastPingTime
+	connectedAt := h.connectedAt
+	h.backoffMu.Unlock()
+
+	var uptime, lastPingAge time.Duration
+	if !connectedAt.IsZero() {
+		uptime = time.Since(connectedAt)
+	}
+	if !lastPing.IsZero() {
+		lastPingAge = time.Since(lastPing)
+	}
+
+	return metrics.ConnectionHealth{
+		Address:        h.hubAddr,
+		ConnectionType: h.ConnectionType,
+		State:          state,
+		Uptime:         uptime,
+		LastPingAge:    lastPingAge,
+		CurrentBackoff: backoff,
+		SpoolDepth:     h.SpoolDepth(),
+		SpoolOldestAge: h.SpoolOldestAge(),
+	}
+}
+
+func (h *HubConnection) applyBackoffDefaults() {
+	if h.InitialBackoff <= 0 {
+		h.InitialBackoff = defaultInitialBackoff
+	}
+	if h.MaxBackoff <= 0 {
+		h.MaxBackoff = defaultMaxBackoff
+	}
+	if h.Multiplier <= 1 {
+		h.Multiplier = defaultMultiplier
+	}
+	if h.JitterFraction <= 0 {
+		h.JitterFraction = defaultJitterFraction
+	}
+}
+
+func (h *HubConnection) applyKeepaliveDefaults() {
+	if h.PingInterval <= 0 {
+		h.PingInterval = defaultPingInterval
+	}
+	if h.PongWait <= 0 {
+		h.PongWait = defaultPongWait
+	}
+	if h.WriteWait <= 0 {
+		h.WriteWait = defaultWriteWait
+	}
+}
+
+//resetBackoff resets the backoff/attempt counters back to their starting state. Called whenever openConnection succeeds.
+func (h *HubConnection) resetBackoff() {
+	h.backoffMu.Lock()
+	defer h.backoffMu.Unlock()
+
+	h.attempt = 0
+	h.currentBackoff = h.InitialBackoff
+	h.backedOff = false
+}
+
+//nextBackoff advances the backoff one step (doubling up to MaxBackoff) and returns the jittered delay to sleep for.
+func (h *HubConnection) nextBackoff() (int, time.Duration) {
+	h.backoffMu.Lock()
+	defer h.backoffMu.Unlock()
+
+	h.attempt++
+
+	if !h.backedOff {
+		// first attempt since the last reset: start at InitialBackoff itself rather than immediately
+		// multiplying it, so the sequence is InitialBackoff, InitialBackoff*Multiplier, ... instead of
+		// skipping straight to the second step.
+		h.currentBackoff = h.InitialBackoff
+		h.backedOff = true
+	} else {
+		h.currentBackoff = time.Duration(float64(h.currentBackoff) * h.Multiplier)
+	}
+	if h.currentBackoff > h.MaxBackoff {
+		h.currentBackoff = h.MaxBackoff
+	}
+
+	// jitter by +/- JitterFraction
+	jitter := (rand.Float64()*2 - 1) * h.JitterFraction
+	delay := time.Duration(float64(h.currentBackoff) * (1 + jitter))
+	if delay < 0 {
+		delay = 0
+	}
+
+	return h.attempt, delay
+}
+
+//setState records the connection's current state under backoffMu, so it's safe to read concurrently
+//from Stats()/Health() while the pumps update it.
+func (h *HubConnection) setState(state string) {
+	h.backoffMu.Lock()
+	h.state = state
+	h.backoffMu.Unlock()
+}
+
+//appendState appends suffix to the current state (e.g. marking it "<state> retrying"), under backoffMu.
+func (h *HubConnection) appendState(suffix string) {
+	h.backoffMu.Lock()
+	h.state = h.state + suffix
+	h.backoffMu.Unlock()
+}
+
+//setConnectedAt records when the connection came up, under backoffMu.
+func (h *HubConnection) setConnectedAt(t time.Time) {
+	h.backoffMu.Lock()
+	h.connectedAt = t
+	h.backoffMu.Unlock()
+}
+
+//setLastPingTime records the last time we saw a ping from the hub, under backoffMu.
+func (h *HubConnection) setLastPingTime(t time.Time) {
+	h.backoffMu.Lock()
+	h.lastPingTime = t
+	h.backoffMu.Unlock()
+}
+
+//getLastPingTime reads the last time we saw a ping from the hub, under backoffMu.
+func (h *HubConnection) getLastPingTime() time.Time {
+	h.backoffMu.Lock()
+	defer h.backoffMu.Unlock()
+	return h.lastPingTime
+}
+
+//SendEvent will queue an event to be sent to the central hub. If SpoolDir is configured the event is
+//durably spooled to disk first, so it survives a hub outage instead of blocking on (or being dropped by)
+//the in-memory write channel.
 func (h *HubConnection) SendEvent(b base.EventWrapper) {
-	h.writeChannel <- b
+	if h.spool != nil {
+		_, dropped, err := h.spool.Append(b)
+		if err != nil {
+			log.L.Errorf("Failed to spool event, dropping: %v", err.Error())
+			metrics.EventsDroppedTotal.WithLabelValues(h.hubAddr, h.ConnectionType, "spool-error").Inc()
+		}
+		if dropped > 0 {
+			metrics.EventsDroppedTotal.WithLabelValues(h.hubAddr, h.ConnectionType, "spool-full").Add(float64(dropped))
+		}
+		return
+	}
+
+	h.writeChannel <- writeMessage{Event: b}
 }
 
 //ReadEvent requests the next available event from the queue
@@ -44,14 +312,130 @@ func (h *HubConnection) ReadEvent() base.EventWrapper {
 	return <-h.readChannel
 }
 
+//Subscribe tells the hub we're interested in the given rooms, which may be glob patterns (e.g.
+//"ITB-*") or "*" for the firehose. The subscription set is remembered and resent automatically after
+//every reconnect.
+func (h *HubConnection) Subscribe(rooms ...string) {
+	h.subMu.Lock()
+	if h.subscriptions == nil {
+		h.subscriptions = make(map[string]bool)
+	}
+	for _, r := range rooms {
+		h.subscriptions[r] = true
+	}
+	h.subMu.Unlock()
+
+	h.sendControlFrame(base.ControlFrame{Type: base.ControlSubscribe, Rooms: rooms})
+}
+
+//Unsubscribe tells the hub we're no longer interested in the given rooms/patterns.
+func (h *HubConnection) Unsubscribe(rooms ...string) {
+	h.subMu.Lock()
+	for _, r := range rooms {
+		delete(h.subscriptions, r)
+	}
+	h.subMu.Unlock()
+
+	h.sendControlFrame(base.ControlFrame{Type: base.ControlUnsubscribe, Rooms: rooms})
+}
+
+//resubscribe resends the full current subscription set to the hub. Called after every successful
+//(re)connect, since the hub has no memory of a receiver's prior subscriptions on a new connection.
+func (h *HubConnection) resubscribe() {
+	h.subMu.Lock()
+	rooms := make([]string, 0, len(h.subscriptions))
+	for r := range h.subscriptions {
+		rooms = append(rooms, r)
+	}
+	h.subMu.Unlock()
+
+	if len(rooms) == 0 {
+		return
+	}
+
+	h.sendControlFrame(base.ControlFrame{Type: base.ControlSubscribe, Rooms: rooms})
+}
+
+//sendControlFrame hands frame to the write pump without blocking. The write pump only runs while
+//we're connected, so while it's down (an outage, a reconnect in progress) there's nobody draining
+//controlChannel; the subscription set driving this frame is already recorded in h.subscriptions and
+//gets resent in full by resubscribe() on the next successful (re)connect, so it's safe to drop a frame
+//here rather than have the caller (or a pile-up of reconnect-triggered resubscribe goroutines) block
+//on a channel nobody's reading.
+func (h *HubConnection) sendControlFrame(frame base.ControlFrame) {
+	select {
+	case h.controlChannel <- frame:
+	default:
+	}
+}
+
+//drainSpool feeds the write pump from the durable spool, oldest entry first, only advancing past an
+//entry once the write pump confirms it made it onto the wire. This is what lets events survive a
+//reconnect instead of being lost while retryConnection() is running.
+//
+//A failed write (e.g. the hub outage this WAL exists to survive) reports back on spoolAcks too, just
+//with OK=false, so we never block forever waiting for an ack that a dead write pump will never send -
+//we just loop back and re-Peek the same still-unacked entry once a new write pump comes up.
+func (h *HubConnection) drainSpool() {
+	for {
+		seq, event, ok := h.spool.Peek()
+		if !ok {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		h.writeChannel <- writeMessage{Seq: seq, Event: event}
+
+		result := <-h.spoolAcks
+		if result.OK {
+			h.spool.Ack(result.Seq)
+		}
+	}
+}
+
+//SpoolDepth returns the number of events durably spooled but not yet acknowledged as sent. Returns 0
+//when no spool is configured.
+func (h *HubConnection) SpoolDepth() int {
+	if h.spool == nil {
+		return 0
+	}
+	return h.spool.Depth()
+}
+
+//SpoolOldestAge returns the age of the oldest durably spooled but unacknowledged event. Returns 0
+//when no spool is configured or it's empty.
+func (h *HubConnection) SpoolOldestAge() time.Duration {
+	if h.spool == nil {
+		return 0
+	}
+	return h.spool.OldestAge()
+}
+
 //ConnectToHub starts a connection to the hub for this hubconnection
 func (h *HubConnection) ConnectToHub(hubAddress string) error {
 	h.hubAddr = hubAddress
+	h.applyBackoffDefaults()
+	h.applyKeepaliveDefaults()
+	if h.Codec == nil {
+		h.Codec = base.BinaryCodec{}
+	}
+	h.resetBackoff()
+
+	if len(h.SpoolDir) > 0 {
+		s, err := spool.Open(h.SpoolDir, h.SpoolMaxBytes)
+		if err != nil {
+			return nerr.Create(fmt.Sprintf("failed to open spool at %v: %s", h.SpoolDir, err), "connection-error")
+		}
+		h.spool = s
+		h.spoolAcks = make(chan spoolResult, 1)
+		go h.drainSpool()
+	}
 
 	// open connection with router
 	err := h.openConnection()
 	if err != nil {
 		log.L.Warnf("Opening connection to hub failed, retrying...")
+		metrics.SetConnectionState(h.hubAddr, h.ConnectionType, "down")
 
 		h.readDone <- true
 		h.writeDone <- true
@@ -61,34 +445,79 @@ func (h *HubConnection) ConnectToHub(hubAddress string) error {
 	}
 
 	// update state to good
-	h.state = "good"
+	h.setState("good")
+	h.setConnectedAt(time.Now())
+	h.resetBackoff()
+	metrics.SetConnectionState(h.hubAddr, h.ConnectionType, "good")
 	log.L.Infof(color.HiGreenString("Successfully connected to hub %s. Starting pumps...", h.hubAddr))
 
 	// start read/write pumps
 	go h.startReadPump()
 	go h.startWritePump()
+	go h.resubscribe()
 
 	return nil
 }
 
 func (h *HubConnection) openConnection() error {
-	// open connection to the router
+	// open connection to the router, advertising our codec as a subprotocol so the hub can pick a
+	// compatible wire format. if the hub doesn't echo back one of ours, we fall back to binary.
 	dialer := &websocket.Dialer{
 		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     []string{h.Codec.Subprotocol(), base.BinarySubprotocol, base.JSONSubprotocol, base.MsgpackSubprotocol},
+		TLSClientConfig:  h.TLSConfig,
 	}
 
-	conn, _, err := dialer.Dial(fmt.Sprintf("ws://%s/connect/%s", h.hubAddr, h.ConnectionType), nil)
+	scheme := "ws"
+	if h.TLSConfig != nil {
+		scheme = "wss"
+	}
+
+	header := http.Header{}
+	if h.TokenSource != nil {
+		token, err := h.TokenSource.Token()
+		if err != nil {
+			return nerr.Create(fmt.Sprintf("failed to get auth token for hub %v: %s", h.hubAddr, err), "connection-error")
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	conn, resp, err := dialer.Dial(fmt.Sprintf("%s://%s/connect/%s", scheme, h.hubAddr, h.ConnectionType), header)
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nerr.Create(fmt.Sprintf("hub %v rejected our token (401), will refresh and retry: %s", h.hubAddr, err), "connection-error")
+		}
 		return nerr.Create(fmt.Sprintf("failed opening websocket with %v: %s", h.hubAddr, err), "connection-error")
 	}
 
 	h.conn = conn
+
+	if resp != nil && len(resp.Header.Get("Sec-WebSocket-Protocol")) > 0 {
+		h.Codec = base.CodecForSubprotocol(resp.Header.Get("Sec-WebSocket-Protocol"))
+	} else {
+		h.Codec = base.BinaryCodec{}
+	}
+
+	// pong handler proves the peer is still alive in response to our own keepalive pings. Extend the
+	// read deadline by our own ping cadence (not just PongWait) so this doesn't shrink the longer
+	// deadline startReadPump's ping handler maintains for inbound hub pings/events - otherwise an idle
+	// connection would time out ~PongWait after every one of our own keepalive pongs.
+	h.conn.SetPongHandler(func(string) error {
+		extend := h.PingInterval + h.PongWait
+		if incomingconnection.PingWait > extend {
+			extend = incomingconnection.PingWait
+		}
+		h.conn.SetReadDeadline(time.Now().Add(extend))
+		return nil
+	})
+
 	return nil
 }
 
 func (h *HubConnection) retryConnection() {
 	// mark the connection as 'down'
-	h.state = h.state + " retrying"
+	h.appendState(" retrying")
+	metrics.SetConnectionState(h.hubAddr, h.ConnectionType, "retrying")
 
 	log.L.Infof("[retry] Retrying connection, waiting for read and write pump to close before starting.")
 	//wait for read to say i'm done.
@@ -100,21 +529,40 @@ func (h *HubConnection) retryConnection() {
 	log.L.Infof("[retry] Write pump closed")
 	log.L.Infof("[retry] Retrying connection")
 
-	//we retry
+	//we retry, backing off (with jitter) between each failed attempt
 	err := h.openConnection()
+	metrics.ReconnectsTotal.WithLabelValues(h.hubAddr, h.ConnectionType).Inc()
 
 	for err != nil {
-		log.L.Infof("[retry] Retry failed, trying to connect to %s again in %v seconds.", h.hubAddr, retryInterval)
-		time.Sleep(retryInterval)
+		attempt, delay := h.nextBackoff()
+
+		if h.MaxAttempts > 0 && attempt >= h.MaxAttempts {
+			log.L.Errorf("[retry] Giving up on connecting to %s after %v attempts.", h.hubAddr, attempt)
+			h.setState("dead")
+			metrics.SetConnectionState(h.hubAddr, h.ConnectionType, "dead")
+			return
+		}
+
+		if h.OnRetry != nil {
+			h.OnRetry(attempt, delay)
+		}
+
+		log.L.Infof("[retry] Retry %v failed, trying to connect to %s again in %v.", attempt, h.hubAddr, delay)
+		time.Sleep(delay)
 		err = h.openConnection()
+		metrics.ReconnectsTotal.WithLabelValues(h.hubAddr, h.ConnectionType).Inc()
 	}
 
 	//start the pumps again
 	log.L.Infof(color.HiGreenString("[Retry] Retry success. Starting pumps"))
 
-	h.state = "good"
+	h.setState("good")
+	h.setConnectedAt(time.Now())
+	h.resetBackoff()
+	metrics.SetConnectionState(h.hubAddr, h.ConnectionType, "good")
 	go h.startReadPump()
 	go h.startWritePump()
+	go h.resubscribe()
 
 }
 
@@ -122,7 +570,8 @@ func (h *HubConnection) startReadPump() {
 	defer func() {
 		h.conn.Close()
 		log.L.Warnf("Connection to hub %v is dying.", h.hubAddr)
-		h.state = "down"
+		h.setState("down")
+		metrics.SetConnectionState(h.hubAddr, h.ConnectionType, "down")
 
 		h.readDone <- true
 	}()
@@ -133,8 +582,7 @@ func (h *HubConnection) startReadPump() {
 			h.conn.SetReadDeadline(time.Now().Add(incomingconnection.PingWait))
 			h.conn.WriteControl(websocket.PongMessage, []byte{}, time.Now().Add(incomingconnection.WriteWait))
 
-			//debugging purposes
-			h.lastPingTime = time.Now()
+			h.setLastPingTime(time.Now())
 
 			return nil
 		})
@@ -151,17 +599,19 @@ func (h *HubConnection) startReadPump() {
 			return
 		}
 
-		if t != websocket.BinaryMessage {
+		if t != h.Codec.MessageType() {
 			log.L.Warnf("Unknown message type %v", t)
 			continue
 		}
 
 		//parse out room name
-		m, er := base.ParseMessage(b)
+		m, er := h.Codec.Decode(b)
 		if er != nil {
 			log.L.Warnf("Poorly formed message %s: %v", b, er.Error())
+			metrics.EventsDroppedTotal.WithLabelValues(h.hubAddr, h.ConnectionType, "decode-error").Inc()
 			continue
 		}
+		metrics.EventsReceivedTotal.WithLabelValues(h.hubAddr, h.ConnectionType).Inc()
 		h.readChannel <- m
 	}
 
@@ -171,7 +621,8 @@ func (h *HubConnection) startWritePump() {
 	defer func() {
 		h.conn.Close()
 		log.L.Warnf("Connection to hub %v is dying. Trying to resurrect.", h.hubAddr)
-		h.state = "down"
+		h.setState("down")
+		metrics.SetConnectionState(h.hubAddr, h.ConnectionType, "down")
 
 		h.writeDone <- true
 
@@ -179,7 +630,19 @@ func (h *HubConnection) startWritePump() {
 		h.retryConnection()
 	}()
 
+	// application-level keepalive so we notice a half-open socket even if the TCP connection stays up
+	pingTicker := time.NewTicker(h.PingInterval)
+	defer pingTicker.Stop()
+
 	for {
+		metrics.WriteQueueDepth.WithLabelValues(h.hubAddr, h.ConnectionType).Set(float64(len(h.writeChannel) + h.SpoolDepth()))
+		metrics.SpoolOldestAgeSeconds.WithLabelValues(h.hubAddr, h.ConnectionType).Set(h.SpoolOldestAge().Seconds())
+		if lastPing := h.getLastPingTime(); !lastPing.IsZero() {
+			// recomputed every loop (at least every PingInterval, via the ticker case below) so this
+			// gauge genuinely ages between hub pings instead of sitting pinned at whatever it was Set to.
+			metrics.LastPingSeconds.WithLabelValues(h.hubAddr, h.ConnectionType).Set(time.Since(lastPing).Seconds())
+		}
+
 		select {
 		case message, ok := <-h.writeChannel:
 			if !ok {
@@ -187,9 +650,50 @@ func (h *HubConnection) startWritePump() {
 				return
 			}
 
-			err := h.conn.WriteMessage(websocket.BinaryMessage, base.PrepareMessage(message))
+			b, err := h.Codec.Encode(message.Event)
+			if err != nil {
+				log.L.Errorf("Problem encoding message: %v", err.Error())
+				metrics.EventsDroppedTotal.WithLabelValues(h.hubAddr, h.ConnectionType, "encode-error").Inc()
+				// an encode error will never succeed on retry, so this has to Ack the entry away
+				// rather than leave it pending - otherwise drainSpool re-Peeks the same unencodable
+				// entry forever and nothing behind it in the spool is ever delivered.
+				if message.Seq != 0 {
+					h.spoolAcks <- spoolResult{Seq: message.Seq, OK: true}
+				}
+				continue
+			}
+
+			err = h.conn.WriteMessage(h.Codec.MessageType(), b)
 			if err != nil {
 				log.L.Errorf("Problem writing message to socket: %v", err.Error())
+				if message.Seq != 0 {
+					h.spoolAcks <- spoolResult{Seq: message.Seq, OK: false}
+				}
+				return
+			}
+			metrics.EventsSentTotal.WithLabelValues(h.hubAddr, h.ConnectionType).Inc()
+
+			if message.Seq != 0 {
+				h.spoolAcks <- spoolResult{Seq: message.Seq, OK: true}
+			}
+
+		case frame := <-h.controlChannel:
+			b, err := base.EncodeControlFrame(frame)
+			if err != nil {
+				log.L.Errorf("Problem encoding control frame: %v", err.Error())
+				continue
+			}
+
+			err = h.conn.WriteMessage(websocket.TextMessage, b)
+			if err != nil {
+				log.L.Errorf("Problem writing control frame to socket: %v", err.Error())
+				return
+			}
+
+		case <-pingTicker.C:
+			err := h.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(h.WriteWait))
+			if err != nil {
+				log.L.Errorf("[%v] Problem sending keepalive ping, assuming connection is half-open: %v", h.hubAddr, err.Error())
 				return
 			}
 
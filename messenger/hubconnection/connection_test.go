@@ -0,0 +1,46 @@
+package hubconnection
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBackoffSequence(t *testing.T) {
+	h := &HubConnection{
+		InitialBackoff: 2,
+		MaxBackoff:     64,
+		Multiplier:     2,
+		JitterFraction: 0, // deterministic
+	}
+	h.resetBackoff()
+
+	want := []time.Duration{2, 4, 8, 16, 32, 64, 64}
+	for i, w := range want {
+		attempt, delay := h.nextBackoff()
+		if attempt != i+1 {
+			t.Errorf("attempt %d: got attempt number %d, want %d", i, attempt, i+1)
+		}
+		if delay != w {
+			t.Errorf("attempt %d: got delay %v, want %v", i, delay, w)
+		}
+	}
+}
+
+func TestNextBackoffResets(t *testing.T) {
+	h := &HubConnection{
+		InitialBackoff: 2,
+		MaxBackoff:     64,
+		Multiplier:     2,
+		JitterFraction: 0,
+	}
+	h.resetBackoff()
+
+	h.nextBackoff()
+	h.nextBackoff()
+
+	h.resetBackoff()
+	_, delay := h.nextBackoff()
+	if delay != h.InitialBackoff {
+		t.Errorf("first delay after reset = %v, want %v", delay, h.InitialBackoff)
+	}
+}
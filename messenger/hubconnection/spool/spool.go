@@ -0,0 +1,328 @@
+//Package spool implements a durable, append-only write-ahead queue for base.EventWrapper events.
+//A Spool survives process restarts and multi-minute hub outages: events are appended to disk with a
+//monotonically increasing sequence number, and are only removed once the caller confirms they were
+//successfully delivered (the on-disk log is compacted once enough removed bytes have piled up). When the on-disk size grows past
+//MaxBytes, the oldest entries are dropped to make room for new ones.
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/central-event-system/hub/base"
+	"github.com/byuoitav/common/nerr"
+)
+
+//defaultMaxBytes is used when a Spool is opened with MaxBytes <= 0.
+const defaultMaxBytes = 64 * 1024 * 1024
+
+const logFileName = "spool.log"
+
+//compactionFraction is the fraction of maxBytes that must have been reclaimed by Ack/eviction (but not
+//yet removed from disk) before rewrite is actually called. Acks and evictions update s.pending and
+//s.deadBytes immediately, but batching the expensive full-file rewrite behind this threshold turns
+//draining N spooled events into amortized O(N) disk IO instead of O(N^2) (a full rewrite per ack).
+const compactionFraction = 0.25
+
+//compactionMinBytes is a floor on the compaction threshold so small spools don't rewrite the whole log
+//every time 25% of a tiny maxBytes (possibly just a few entries) has been reclaimed.
+const compactionMinBytes = 64 * 1024
+
+//entry is a single record in the on-disk log: a sequence number, the time it was appended (so
+//oldest-event age can be computed), and a length-prefixed base.EventWrapper encoded with base.BinaryCodec.
+type entry struct {
+	Seq       uint64
+	Timestamp time.Time
+	Event     base.EventWrapper
+}
+
+//Spool is a durable FIFO queue of events backed by a single append-only log file on disk. It is safe
+//for concurrent use.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	deadBytes int64 // bytes of acked/evicted entries still physically on disk, not yet compacted away
+	nextSeq   uint64
+	pending   []entry // unacknowledged entries, oldest first
+	lastAck   uint64
+}
+
+//Open opens (or creates) a Spool rooted at dir. maxBytes bounds the on-disk size of the log; once
+//exceeded, the oldest unacknowledged entries are dropped to make room. maxBytes <= 0 uses a 64MB default.
+func Open(dir string, maxBytes int64) (*Spool, *nerr.E) {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxBytes
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, nerr.Create(fmt.Sprintf("failed to create spool directory %v: %s", dir, err), "spool-error")
+	}
+
+	path := filepath.Join(dir, logFileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nerr.Create(fmt.Sprintf("failed to open spool log %v: %s", path, err), "spool-error")
+	}
+
+	s := &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		file:     f,
+	}
+
+	er := s.loadExisting()
+	if er != nil {
+		f.Close()
+		return nil, er
+	}
+
+	return s, nil
+}
+
+//loadExisting replays the on-disk log into memory so a restarted process picks up where it left off.
+func (s *Spool) loadExisting() *nerr.E {
+	_, err := s.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nerr.Create(fmt.Sprintf("failed to seek spool log: %s", err), "spool-error")
+	}
+
+	r := bufio.NewReader(s.file)
+	var size int64
+
+	for {
+		e, n, err := readEntry(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			//a partial/corrupt trailing write from a prior crash - stop replaying, keep what we have.
+			break
+		}
+
+		s.pending = append(s.pending, e)
+		size += int64(n)
+		if e.Seq > s.nextSeq {
+			s.nextSeq = e.Seq
+		}
+	}
+
+	s.size = size
+	_, err = s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nerr.Create(fmt.Sprintf("failed to seek spool log to end: %s", err), "spool-error")
+	}
+
+	return nil
+}
+
+//Append durably writes e to the spool and returns its sequence number. If the spool is at MaxBytes,
+//the oldest unacknowledged entries are dropped to make room; dropped is how many of them there were,
+//so the caller can record the loss (e.g. a metrics counter) instead of it being silent.
+func (s *Spool) Append(e base.EventWrapper) (seq uint64, dropped int, nErr *nerr.E) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextSeq++
+	seq = s.nextSeq
+
+	ent := entry{Seq: seq, Timestamp: time.Now(), Event: e}
+	b := encodeEntry(ent)
+	n, err := s.file.Write(b)
+	if err != nil {
+		return 0, 0, nerr.Create(fmt.Sprintf("failed to append to spool log: %s", err), "spool-error")
+	}
+
+	s.pending = append(s.pending, ent)
+	s.size += int64(n)
+
+	for s.size > s.maxBytes && len(s.pending) > 1 {
+		d := s.pending[0]
+		s.pending = s.pending[1:]
+		dn := int64(len(encodeEntry(d)))
+		s.size -= dn
+		s.deadBytes += dn
+		dropped++
+	}
+
+	if er := s.maybeCompact(); er != nil {
+		return seq, dropped, er
+	}
+
+	return seq, dropped, nil
+}
+
+//maybeCompact calls rewrite once enough dead (acked/evicted) bytes have piled up on disk to be worth
+//the cost of a full rewrite, rather than on every single Ack/eviction. Called with s.mu held.
+func (s *Spool) maybeCompact() *nerr.E {
+	threshold := int64(float64(s.maxBytes) * compactionFraction)
+	if threshold < compactionMinBytes {
+		threshold = compactionMinBytes
+	}
+	if s.deadBytes < threshold {
+		return nil
+	}
+
+	return s.rewrite()
+}
+
+//rewrite replaces the on-disk log with exactly s.pending, reclaiming the space used by every entry
+//acked or evicted since the last rewrite. Called with s.mu held.
+func (s *Spool) rewrite() *nerr.E {
+	path := filepath.Join(s.dir, logFileName)
+	tmpPath := path + ".compact"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nerr.Create(fmt.Sprintf("failed to create spool compaction file %v: %s", tmpPath, err), "spool-error")
+	}
+
+	var size int64
+	for _, e := range s.pending {
+		b := encodeEntry(e)
+		if _, err = f.Write(b); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return nerr.Create(fmt.Sprintf("failed to write spool compaction file %v: %s", tmpPath, err), "spool-error")
+		}
+		size += int64(len(b))
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return nerr.Create(fmt.Sprintf("failed to close spool compaction file %v: %s", tmpPath, err), "spool-error")
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nerr.Create(fmt.Sprintf("failed to replace spool log with compacted file: %s", err), "spool-error")
+	}
+
+	s.file.Close()
+	f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nerr.Create(fmt.Sprintf("failed to reopen compacted spool log %v: %s", path, err), "spool-error")
+	}
+
+	s.file = f
+	s.size = size
+	s.deadBytes = 0
+	return nil
+}
+
+//Peek returns the oldest unacknowledged entry without removing it, or ok=false if the spool is empty.
+func (s *Spool) Peek() (seq uint64, e base.EventWrapper, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return 0, base.EventWrapper{}, false
+	}
+
+	return s.pending[0].Seq, s.pending[0].Event, true
+}
+
+//Ack removes every pending entry up to and including seq, marking it delivered. The on-disk log isn't
+//necessarily compacted immediately - see maybeCompact - so a crash shortly after Ack can see a handful
+//of already-delivered entries replayed again on restart; callers already need to tolerate at-least-once
+//delivery (that's why entries carry a Seq at all), so this only matters for bounding how many.
+//Acks are expected to arrive in order since entries are drained oldest-first.
+func (s *Spool) Ack(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.pending) > 0 && s.pending[0].Seq <= seq {
+		d := s.pending[0]
+		s.pending = s.pending[1:]
+		s.deadBytes += int64(len(encodeEntry(d)))
+	}
+	s.lastAck = seq
+
+	// best-effort: a failed compaction just means the log stays larger than ideal until the next
+	// successful one - replay correctness (which events get resent) is unaffected.
+	s.maybeCompact()
+}
+
+//Depth returns the number of unacknowledged events currently held in the spool.
+func (s *Spool) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.pending)
+}
+
+//OldestAge returns the age of the oldest unacknowledged entry, or 0 if the spool is empty.
+func (s *Spool) OldestAge() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return 0
+	}
+
+	return time.Since(s.pending[0].Timestamp)
+}
+
+//LastAck returns the highest sequence number acknowledged so far, for replay-from-last-ack on reconnect.
+func (s *Spool) LastAck() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastAck
+}
+
+//Close closes the underlying log file.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+//encodeEntry frames an entry as [8-byte seq][8-byte unix-nano timestamp][4-byte length][binary-codec-encoded EventWrapper].
+func encodeEntry(e entry) []byte {
+	payload := base.PrepareMessage(e.Event)
+
+	b := make([]byte, 8+8+4+len(payload))
+	binary.BigEndian.PutUint64(b[0:8], e.Seq)
+	binary.BigEndian.PutUint64(b[8:16], uint64(e.Timestamp.UnixNano()))
+	binary.BigEndian.PutUint32(b[16:20], uint32(len(payload)))
+	copy(b[20:], payload)
+
+	return b
+}
+
+//readEntry reads one framed entry from r, returning the entry and the number of bytes consumed.
+func readEntry(r *bufio.Reader) (entry, int, error) {
+	header := make([]byte, 20)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return entry{}, 0, err
+	}
+
+	seq := binary.BigEndian.Uint64(header[0:8])
+	timestamp := int64(binary.BigEndian.Uint64(header[8:16]))
+	length := binary.BigEndian.Uint32(header[16:20])
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return entry{}, 0, err
+	}
+
+	ev, parseErr := base.ParseMessage(payload)
+	if parseErr != nil {
+		return entry{}, 0, parseErr
+	}
+
+	return entry{Seq: seq, Timestamp: time.Unix(0, timestamp), Event: ev}, len(header) + len(payload), nil
+}
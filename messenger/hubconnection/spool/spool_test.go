@@ -0,0 +1,133 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/byuoitav/central-event-system/hub/base"
+)
+
+func openTestSpool(t *testing.T, maxBytes int64) *Spool {
+	t.Helper()
+
+	s, err := Open(t.TempDir(), maxBytes)
+	if err != nil {
+		t.Fatalf("Open: %s", err.Error())
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestAppendPeekAckOrdering(t *testing.T) {
+	s := openTestSpool(t, 0)
+
+	seq1, _, err := s.Append(base.EventWrapper{Room: "a", Event: []byte("1")})
+	if err != nil {
+		t.Fatalf("Append: %s", err.Error())
+	}
+	seq2, _, err := s.Append(base.EventWrapper{Room: "a", Event: []byte("2")})
+	if err != nil {
+		t.Fatalf("Append: %s", err.Error())
+	}
+
+	if s.Depth() != 2 {
+		t.Fatalf("Depth() = %v, want 2", s.Depth())
+	}
+
+	seq, event, ok := s.Peek()
+	if !ok || seq != seq1 || string(event.Event) != "1" {
+		t.Fatalf("Peek() = (%v, %+v, %v), want first appended entry", seq, event, ok)
+	}
+
+	s.Ack(seq1)
+	if s.Depth() != 1 {
+		t.Fatalf("Depth() after ack = %v, want 1", s.Depth())
+	}
+
+	seq, _, ok = s.Peek()
+	if !ok || seq != seq2 {
+		t.Fatalf("Peek() after ack = %v, want %v", seq, seq2)
+	}
+}
+
+func TestAppendDropsOldestWhenOverMaxBytes(t *testing.T) {
+	// a 1-byte budget means every append after the first leaves the spool over maxBytes, so each one
+	// evicts everything older than the newest entry.
+	s := openTestSpool(t, 1)
+
+	s.Append(base.EventWrapper{Room: "a", Event: []byte("1")})
+	s.Append(base.EventWrapper{Room: "a", Event: []byte("2")})
+	_, dropped, err := s.Append(base.EventWrapper{Room: "a", Event: []byte("3")})
+	if err != nil {
+		t.Fatalf("Append: %s", err.Error())
+	}
+
+	if dropped == 0 {
+		t.Fatalf("Append() dropped = %v, want > 0 once over MaxBytes", dropped)
+	}
+	if s.Depth() != 1 {
+		t.Fatalf("Depth() = %v, want 1 (oldest entries should be dropped)", s.Depth())
+	}
+
+	_, event, ok := s.Peek()
+	if !ok || string(event.Event) != "3" {
+		t.Fatalf("Peek() = %+v, want the most recently appended entry to survive", event)
+	}
+}
+
+func TestOldestAge(t *testing.T) {
+	s := openTestSpool(t, 0)
+
+	if age := s.OldestAge(); age != 0 {
+		t.Fatalf("OldestAge() on empty spool = %v, want 0", age)
+	}
+
+	seq, _, err := s.Append(base.EventWrapper{Room: "a", Event: []byte("1")})
+	if err != nil {
+		t.Fatalf("Append: %s", err.Error())
+	}
+
+	if age := s.OldestAge(); age <= 0 {
+		t.Fatalf("OldestAge() = %v, want > 0 once an entry is pending", age)
+	}
+
+	s.Ack(seq)
+	if age := s.OldestAge(); age != 0 {
+		t.Fatalf("OldestAge() after acking the only entry = %v, want 0", age)
+	}
+}
+
+func TestAckDefersCompactionUntilThreshold(t *testing.T) {
+	// acking a single entry out of a log well under compactionMinBytes should track the reclaimed
+	// space in memory without paying for a full-file rewrite yet - otherwise draining N spooled
+	// events after a reconnect would cost a rewrite per ack again.
+	s := openTestSpool(t, 0)
+
+	seq1, _, err := s.Append(base.EventWrapper{Room: "a", Event: []byte("1")})
+	if err != nil {
+		t.Fatalf("Append: %s", err.Error())
+	}
+	s.Append(base.EventWrapper{Room: "a", Event: []byte("2")})
+
+	path := filepath.Join(s.dir, logFileName)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+
+	s.Ack(seq1)
+
+	if s.deadBytes == 0 {
+		t.Fatalf("deadBytes after ack = 0, want > 0 (reclaimed space should be tracked, not compacted away)")
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if after.Size() != before.Size() {
+		t.Fatalf("on-disk size after a single ack = %v, want unchanged %v until the compaction threshold accumulates", after.Size(), before.Size())
+	}
+}
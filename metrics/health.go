@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+//ConnectionHealth is a point-in-time snapshot of a single hub/repeater connection, suitable for
+//wiring into a /healthz endpoint on the containing binary.
+type ConnectionHealth struct {
+	Address        string        `json:"address"`
+	ConnectionType string        `json:"connectionType"`
+	State          string        `json:"state"`
+	Uptime         time.Duration `json:"uptime"`
+	LastPingAge    time.Duration `json:"lastPingAge"`
+	CurrentBackoff time.Duration `json:"currentBackoff"`
+	SpoolDepth     int           `json:"spoolDepth"`
+	SpoolOldestAge time.Duration `json:"spoolOldestAge"`
+}
+
+//HealthProvider is implemented by anything that can report its own ConnectionHealth - e.g.
+//hubconnection.HubConnection and repeater.PumpingStation.
+type HealthProvider interface {
+	Health() ConnectionHealth
+}
+
+//Handler returns an http.Handler that serves the current ConnectionHealth of every given provider as
+//a JSON array, suitable for mounting at /healthz.
+func Handler(providers ...HealthProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := make([]ConnectionHealth, len(providers))
+		for i, p := range providers {
+			health[i] = p.Health()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		err := json.NewEncoder(w).Encode(health)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+//Package metrics exposes Prometheus collectors and a JSON health handler for hub/repeater
+//connections (hubconnection.HubConnection, repeater.PumpingStation). Both types today only surface their
+//state via log lines; this package gives operators something to alert on instead.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	//ConnectionState is 1 for the state a connection currently reports, 0 otherwise - e.g.
+	//ces_hub_connection_state{addr="...",type="...",state="good"} == 1.
+	ConnectionState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ces_hub_connection_state",
+		Help: "Current state (good/down/retrying/dead) of a hub/repeater connection, as a 1/0 gauge per state label.",
+	}, []string{"addr", "type", "state"})
+
+	//EventsSentTotal counts events successfully written to the socket.
+	EventsSentTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ces_events_sent_total",
+		Help: "Total number of events successfully sent.",
+	}, []string{"addr", "type"})
+
+	//EventsReceivedTotal counts events successfully read off the socket.
+	EventsReceivedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ces_events_received_total",
+		Help: "Total number of events successfully received.",
+	}, []string{"addr", "type"})
+
+	//EventsDroppedTotal counts events that never made it onto (or off of) the wire, broken out by reason
+	//(e.g. "spool-error", "encode-error", "spool-full").
+	EventsDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ces_events_dropped_total",
+		Help: "Total number of events dropped, labeled by reason.",
+	}, []string{"addr", "type", "reason"})
+
+	//ReconnectsTotal counts completed reconnect attempts (successful or not).
+	ReconnectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ces_reconnects_total",
+		Help: "Total number of reconnect attempts made.",
+	}, []string{"addr", "type"})
+
+	//WriteQueueDepth is the current depth of the outbound queue (in-memory write channel, or spool when configured).
+	WriteQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ces_write_queue_depth",
+		Help: "Current number of events queued to be written (write channel or spool).",
+	}, []string{"addr", "type"})
+
+	//LastPingSeconds is the age, in seconds, of the last ping/pong seen on the connection.
+	LastPingSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ces_last_ping_seconds",
+		Help: "Seconds since the last ping/pong was seen on the connection.",
+	}, []string{"addr", "type"})
+
+	//SpoolOldestAgeSeconds is the age, in seconds, of the oldest durably spooled but unacknowledged event.
+	SpoolOldestAgeSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ces_spool_oldest_age_seconds",
+		Help: "Age in seconds of the oldest unacknowledged event in the durable spool, 0 if empty or unconfigured.",
+	}, []string{"addr", "type"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ConnectionState,
+		EventsSentTotal,
+		EventsReceivedTotal,
+		EventsDroppedTotal,
+		ReconnectsTotal,
+		WriteQueueDepth,
+		LastPingSeconds,
+		SpoolOldestAgeSeconds,
+	)
+}
+
+//connectionStates enumerates every state value ConnectionState can report, so SetConnectionState can
+//zero out the ones that no longer apply instead of leaving stale 1s behind.
+var connectionStates = []string{"good", "down", "retrying", "dead"}
+
+//SetConnectionState records addr/type as currently being in state, and zeroes out every other known state.
+func SetConnectionState(addr, connType, state string) {
+	for _, s := range connectionStates {
+		if s == state {
+			ConnectionState.WithLabelValues(addr, connType, s).Set(1)
+		} else {
+			ConnectionState.WithLabelValues(addr, connType, s).Set(0)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package repeater
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/byuoitav/central-event-system/hub/base"
+	"github.com/byuoitav/central-event-system/metrics"
 	"github.com/byuoitav/common/db"
 	"github.com/byuoitav/common/log"
 	"github.com/byuoitav/common/nerr"
@@ -22,11 +27,23 @@ const (
 
 	//port for the translators on the devices
 	translatorport = "6998"
+
+	//defaultPingInterval is how often the write pump sends an application-level ping to the peer.
+	defaultPingInterval = 30 * time.Second
+
+	//defaultPongWait is how long we'll wait for a pong before assuming the socket is half-open.
+	defaultPongWait = 10 * time.Second
+
+	//defaultWriteWait bounds how long a single write (including ping control frames) is allowed to take.
+	defaultWriteWait = 10 * time.Second
+
+	//connTypeRepeater labels this connection's metrics/health as coming from a repeater pumping station.
+	connTypeRepeater = "repeater"
 )
 
 //PumpingStation .
 type PumpingStation struct {
-	conn websocket.Conn
+	conn *websocket.Conn
 
 	ID   string
 	Room string
@@ -34,8 +51,8 @@ type PumpingStation struct {
 	remoteaddr string
 
 	//internal channels
-	readChannel  chan event.Event
-	writeChannel chan event.Event
+	readChannel  chan events.Event
+	writeChannel chan events.Event
 
 	readExit  chan bool
 	writeExit chan bool
@@ -44,9 +61,35 @@ type PumpingStation struct {
 	writeTimeout time.Time
 	readTimeout  time.Time
 
+	connectedAt time.Time
+	state       string
+
+	pongMu sync.Mutex
+	// pongDeadline is the time by which we must have seen a pong proving the peer is still alive. It's
+	// the zero value whenever there's no outstanding ping (including right after connect, before the
+	// first one is sent): startWritePump sets it to sendTime+PongWait when it sends a keepalive ping
+	// while no earlier ping is outstanding, and the pong handler installed in openConn clears it back to zero once that ping
+	// is answered. Unlike the TTL read deadline (which only proves the socket is open), this is what
+	// tells startReadPump a wedged peer has stopped answering our keepalive pings. Guarded by pongMu
+	// since it's written from both the write pump and the pong handler (which runs on the read
+	// goroutine) and read from the read pump.
+	pongDeadline time.Time
+
+	//PingInterval, PongWait, and WriteWait configure the application-level keepalive sent to the peer. Defaults applied in StartConnection/buildFromConnection.
+	PingInterval time.Duration
+	PongWait     time.Duration
+	WriteWait    time.Duration
+
+	//TokenSource, if set, supplies a bearer token sent as "Authorization: Bearer <token>" on every dial
+	//to the translator. Consulted fresh on every openConn call.
+	TokenSource base.TokenSource
+
+	//TLSConfig, if set, dials the translator with wss:// using this config instead of plaintext ws://.
+	TLSConfig *tls.Config
+
 	//external channels
-	ReceiveChannel chan event.Event
-	SendChannel    chan event.Event
+	ReceiveChannel chan events.Event
+	SendChannel    chan events.Event
 
 	r *Repeater
 }
@@ -54,17 +97,20 @@ type PumpingStation struct {
 //StartConnection takes a proc number, and will build the buffers, return it while asyncronously starting the connection
 func StartConnection(proc, room string, r *Repeater) (*PumpingStation, *nerr.E) {
 
-	toreturn := &pumpingStation{
-		readChannel:    make(chan event.Event, readBufferSize),
-		writeChannel:   make(chan event.Event, writeBufferSize),
+	toreturn := &PumpingStation{
+		readChannel:    make(chan events.Event, readBufferSize),
+		writeChannel:   make(chan events.Event, writeBufferSize),
 		ReceiveChannel: r.HubSendBuffer,
-		SendChannel:    make(chan event.Event, writeBufferSize),
+		SendChannel:    make(chan events.Event, writeBufferSize),
 		readExit:       make(chan bool, 1),
 		writeExit:      make(chan bool, 1),
 		errorChan:      make(chan error, 2),
 		ID:             proc,
 		Room:           room,
 		r:              r,
+		PingInterval:   defaultPingInterval,
+		PongWait:       defaultPongWait,
+		WriteWait:      defaultWriteWait,
 	}
 
 	go toreturn.start()
@@ -74,11 +120,11 @@ func StartConnection(proc, room string, r *Repeater) (*PumpingStation, *nerr.E)
 
 func buildFromConnection(proc, room string, r *Repeater, conn *websocket.Conn) (*PumpingStation, *nerr.E) {
 
-	toreturn := &pumpingStation{
-		readChannel:    make(chan event.Event, readBufferSize),
-		writeChannel:   make(chan event.Event, writeBufferSize),
+	toreturn := &PumpingStation{
+		readChannel:    make(chan events.Event, readBufferSize),
+		writeChannel:   make(chan events.Event, writeBufferSize),
 		ReceiveChannel: r.HubSendBuffer,
-		SendChannel:    make(chan event.Event, writeBufferSize),
+		SendChannel:    make(chan events.Event, writeBufferSize),
 		readExit:       make(chan bool, 1),
 		writeExit:      make(chan bool, 1),
 		errorChan:      make(chan error, 2),
@@ -87,6 +133,9 @@ func buildFromConnection(proc, room string, r *Repeater, conn *websocket.Conn) (
 		r:              r,
 		conn:           conn,
 		remoteaddr:     conn.RemoteAddr().String(),
+		PingInterval:   defaultPingInterval,
+		PongWait:       defaultPongWait,
+		WriteWait:      defaultWriteWait,
 	}
 
 	go toreturn.startReadPump()
@@ -99,13 +148,13 @@ func buildFromConnection(proc, room string, r *Repeater, conn *websocket.Conn) (
 func (c *PumpingStation) start() {
 	//we need to get the address of the processor I want to talk to a
 	dev, err := db.GetDB().GetDevice(c.ID)
-	if er != nil {
-		log.L.Errorf("Couldn't retrieve device %v from database: %v", c.ID, er.Error())
+	if err != nil {
+		log.L.Errorf("Couldn't retrieve device %v from database: %v", c.ID, err.Error())
 		c.r.UnregisterConnection(c.ID)
 		return
 	}
 
-	err := c.openConn(dev.Address)
+	err = c.openConn(dev.Address)
 	if err != nil {
 		log.L.Errorf("couldn't initializle for %v: %v", c.ID, err.Error())
 		c.r.UnregisterConnection(c.ID)
@@ -114,43 +163,99 @@ func (c *PumpingStation) start() {
 
 	go c.startReadPump()
 	go c.startWritePump()
-	c.startpumper()
+	c.startPumper()
 }
 
 func (c *PumpingStation) openConn(addr string) *nerr.E {
 	log.L.Debugf("Starting connection with %v", addr)
 
-	c.remoteaddr = dev.Address
+	c.remoteaddr = addr
 
 	dialer := &websocket.Dialer{
 		HandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:  c.TLSConfig,
 	}
 
-	conn, _, err := dialer.Dial(fmt.Sprintf("ws://%s:%s/repeaterconn", addr, translatorport), nil)
+	scheme := "ws"
+	if c.TLSConfig != nil {
+		scheme = "wss"
+	}
+
+	header := http.Header{}
+	if c.TokenSource != nil {
+		token, tokenErr := c.TokenSource.Token()
+		if tokenErr != nil {
+			return nerr.Create(fmt.Sprintf("failed to get auth token for %v: %s", addr, tokenErr), "connection-error")
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	conn, resp, err := dialer.Dial(fmt.Sprintf("%s://%s:%s/repeaterconn", scheme, addr, translatorport), header)
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nerr.Create(fmt.Sprintf("%v rejected our token (401): %s", addr, err), "connection-error")
+		}
 		return nerr.Create(fmt.Sprintf("failed opening websocket with %v: %s", addr, err), "connection-error")
 	}
 	log.L.Debugf("Connection started with %v", addr)
 
 	c.conn = conn
+	c.state = "good"
+	c.connectedAt = time.Now()
+	metrics.SetConnectionState(c.remoteaddr, connTypeRepeater, "good")
+
+	// pong handler proves the peer is still alive in response to our own keepalive pings. Clear
+	// pongDeadline back to zero rather than re-arming it here - the next deadline only gets set once
+	// startWritePump actually sends the next ping, PingInterval from now.
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(c.PongWait))
+		c.setPongDeadline(time.Time{})
+		return nil
+	})
+
 	return nil
 }
 
-//We don't try to re-establish this one, nor do we worry about ping/pong joy - we're alive until one of us closes it - hopefully 5 seconds of inactivity
+//setPongDeadline records pongDeadline under pongMu, safe to call from the write pump (arming it) or
+//the pong handler (clearing it), both of which run concurrently with startReadPump's reads of it.
+func (c *PumpingStation) setPongDeadline(t time.Time) {
+	c.pongMu.Lock()
+	c.pongDeadline = t
+	c.pongMu.Unlock()
+}
+
+//getPongDeadline reads pongDeadline under pongMu.
+func (c *PumpingStation) getPongDeadline() time.Time {
+	c.pongMu.Lock()
+	defer c.pongMu.Unlock()
+	return c.pongDeadline
+}
+
+//We don't try to re-establish this one - we're alive until one of us closes it - hopefully 5 seconds of inactivity. We do ping the peer to prove liveness, since the TTL-based read deadline alone can't tell a wedged peer from a quiet one.
 func (c *PumpingStation) startReadPump() {
 
 	c.conn.SetReadDeadline(time.Now().Add(TTL))
 	for {
 		var event events.Event
-		t, b, err := c.conn.ReadJSON(&event)
+		err := c.conn.ReadJSON(&event)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
 				log.L.Errorf("[%v] Websocket closing: %v", c.ID, err)
 			} else {
 				netErr, ok := err.(net.Error)
 				if ok && netErr.Timeout() {
+					// a TTL read timeout alone only proves the socket is open, not that the peer is
+					// still alive - only tear down if there's an outstanding ping (pongDeadline is
+					// non-zero) that's gone unanswered past PongWait. A zero pongDeadline means either
+					// no ping has been sent yet or the last one was already answered.
+					if deadline := c.getPongDeadline(); !deadline.IsZero() && time.Now().After(deadline) {
+						log.L.Warnf("[%v] No pong received within %v, treating peer as dead", c.ID, c.PongWait)
+						c.errorChan <- err
+						return
+					}
+
 					select {
-					case <-readExit:
+					case <-c.readExit:
 						return
 					default:
 						c.conn.SetReadDeadline(time.Now().Add(TTL))
@@ -158,12 +263,13 @@ func (c *PumpingStation) startReadPump() {
 					}
 				}
 			}
-			log.L.Debugf("[%v] Returning", c.ID, err)
+			log.L.Debugf("[%v] Returning: %v", c.ID, err)
 			c.errorChan <- err
 			return
 		}
 
-		c.readChannel <- m
+		metrics.EventsReceivedTotal.WithLabelValues(c.remoteaddr, connTypeRepeater).Inc()
+		c.readChannel <- event
 
 		c.conn.SetReadDeadline(time.Now().Add(TTL))
 	}
@@ -171,11 +277,14 @@ func (c *PumpingStation) startReadPump() {
 
 func (c *PumpingStation) startWritePump() {
 
-	c.conn.SetWriteDeadline = time.Now().Add(TTL)
+	c.conn.SetWriteDeadline(time.Now().Add(TTL))
+
+	pingTicker := time.NewTicker(c.PingInterval)
+	defer pingTicker.Stop()
 
 	for {
 		select {
-		case msg <- c.writeChannel:
+		case msg := <-c.writeChannel:
 			//in the case of the write channel we just write it down the socket
 			err := c.conn.WriteJSON(msg)
 			if err != nil {
@@ -183,7 +292,23 @@ func (c *PumpingStation) startWritePump() {
 				c.errorChan <- err
 				return
 			}
-			c.conn.SetWriteDeadline = time.Now().Add(TTL)
+			metrics.EventsSentTotal.WithLabelValues(c.remoteaddr, connTypeRepeater).Inc()
+			c.conn.SetWriteDeadline(time.Now().Add(TTL))
+
+		case <-pingTicker.C:
+			//application-level keepalive, to prove the peer is still alive and not just leaving the socket open
+			err := c.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(c.WriteWait))
+			if err != nil {
+				log.L.Warnf("[%v] Problem sending keepalive ping, assuming connection is half-open: %v", c.ID, err.Error())
+				c.errorChan <- err
+				return
+			}
+			// arm the deadline for this ping now that it's actually on the wire, unless an earlier
+			// ping is still unanswered (re-arming would keep pushing its deadline out); the pong
+			// handler clears it back to zero once (if) it's answered.
+			if c.getPongDeadline().IsZero() {
+				c.setPongDeadline(time.Now().Add(c.PongWait))
+			}
 
 		case <-c.writeExit:
 			return
@@ -193,7 +318,10 @@ func (c *PumpingStation) startWritePump() {
 
 func (c *PumpingStation) startPumper() {
 	defer func() {
-		r.UnregisterConnection(c.ID)
+		c.state = "down"
+		metrics.SetConnectionState(c.remoteaddr, connTypeRepeater, "down")
+
+		c.r.UnregisterConnection(c.ID)
 
 		c.writeExit <- true
 		c.readExit <- true
@@ -232,6 +360,23 @@ func (c *PumpingStation) startPumper() {
 }
 
 //SendEvent .
-func (c *PumpingStation) SendEvent(e event.Event) {
+func (c *PumpingStation) SendEvent(e events.Event) {
 	c.SendChannel <- e
 }
+
+//Health implements metrics.HealthProvider, reporting enough state to wire this connection into a /healthz endpoint.
+func (c *PumpingStation) Health() metrics.ConnectionHealth {
+	var uptime time.Duration
+	if !c.connectedAt.IsZero() {
+		uptime = time.Since(c.connectedAt)
+	}
+
+	return metrics.ConnectionHealth{
+		Address:        c.remoteaddr,
+		ConnectionType: connTypeRepeater,
+		State:          c.state,
+		Uptime:         uptime,
+		CurrentBackoff: 0, // PumpingStation doesn't retry once closed, see startReadPump's doc comment.
+		SpoolDepth:     len(c.writeChannel),
+	}
+}
@@ -0,0 +1,162 @@
+package repeater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+	"github.com/gorilla/websocket"
+)
+
+//dialTestPumpingStation spins up a websocket test server running handler, dials it, and returns a
+//PumpingStation wired directly to that connection (bypassing StartConnection/db/Repeater, which this
+//package doesn't have test doubles for) so the read/write pumps can be exercised against a real peer.
+func dialTestPumpingStation(t *testing.T, handler http.HandlerFunc) (*PumpingStation, func()) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		server.Close()
+		t.Fatalf("dial: %s", err)
+	}
+
+	c := &PumpingStation{
+		conn:         conn,
+		readChannel:  make(chan events.Event, readBufferSize),
+		writeChannel: make(chan events.Event, writeBufferSize),
+		readExit:     make(chan bool, 1),
+		writeExit:    make(chan bool, 1),
+		errorChan:    make(chan error, 2),
+		PingInterval: 10 * time.Millisecond,
+		PongWait:     50 * time.Millisecond,
+		WriteWait:    time.Second,
+	}
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(c.PongWait))
+		c.setPongDeadline(time.Time{})
+		return nil
+	})
+
+	return c, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestStartWritePumpArmsPongDeadlineOnPingSend(t *testing.T) {
+	pinged := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+
+	c, cleanup := dialTestPumpingStation(t, func(w http.ResponseWriter, r *http.Request) {
+		peer, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		peer.SetPingHandler(func(string) error {
+			select {
+			case pinged <- struct{}{}:
+			default:
+			}
+			return nil // never pong back, so this peer looks wedged
+		})
+		peer.ReadMessage()
+	})
+	defer cleanup()
+
+	if !c.getPongDeadline().IsZero() {
+		t.Fatal("pongDeadline should start zero: no ping has been sent yet")
+	}
+
+	go c.startWritePump()
+	defer func() { c.writeExit <- true }()
+
+	select {
+	case <-pinged:
+	case <-time.After(time.Second):
+		t.Fatal("peer never received a keepalive ping")
+	}
+
+	if c.getPongDeadline().IsZero() {
+		t.Error("pongDeadline should be armed once the keepalive ping is actually sent")
+	}
+}
+
+func TestPongHandlerClearsPongDeadline(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	c, cleanup := dialTestPumpingStation(t, func(w http.ResponseWriter, r *http.Request) {
+		peer, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		peer.SetPingHandler(func(string) error {
+			return peer.WriteControl(websocket.PongMessage, nil, time.Now().Add(time.Second))
+		})
+		for {
+			if _, _, err := peer.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer cleanup()
+
+	go c.startWritePump()
+	defer func() { c.writeExit <- true }()
+	go c.startReadPump()
+	defer func() { c.readExit <- true }()
+
+	// give the write pump a chance to send a ping and arm the deadline before asserting it clears.
+	for i := 0; i < 100 && c.getPongDeadline().IsZero(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	deadline := c.getPongDeadline()
+	if deadline.IsZero() {
+		t.Fatal("pongDeadline never got armed by a keepalive ping")
+	}
+
+	for i := 0; i < 100 && !c.getPongDeadline().IsZero(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if !c.getPongDeadline().IsZero() {
+		t.Error("pongDeadline should be cleared back to zero once the peer's pong is processed")
+	}
+}
+
+func TestReadPumpTearsDownWedgedPeer(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	c, cleanup := dialTestPumpingStation(t, func(w http.ResponseWriter, r *http.Request) {
+		peer, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		// never answer pings - simulates a wedged peer whose TCP socket stays open. The default ping
+		// handler would pong back, so replace it with one that doesn't.
+		peer.SetPingHandler(func(string) error { return nil })
+		peer.ReadMessage()
+	})
+	defer cleanup()
+
+	go c.startWritePump()
+	defer func() { c.writeExit <- true }()
+	go c.startReadPump()
+
+	// startReadPump only re-examines pongDeadline when its TTL-based read deadline lapses, so this has
+	// to wait out at least one TTL (5s) on top of PongWait before the wedged peer gets torn down.
+	select {
+	case err := <-c.errorChan:
+		if err == nil {
+			t.Error("expected a non-nil error when the wedged peer's pong deadline lapses")
+		}
+	case <-time.After(TTL + 2*time.Second):
+		t.Fatal("startReadPump never gave up on the wedged peer")
+	}
+}